@@ -0,0 +1,62 @@
+package volumes
+
+import (
+	"fmt"
+
+	"k8s.io/utils/exec"
+)
+
+// FSType identifies a filesystem a volume can be formatted with.
+type FSType string
+
+const (
+	FSTypeExt4  FSType = "ext4"
+	FSTypeExt3  FSType = "ext3"
+	FSTypeXFS   FSType = "xfs"
+	FSTypeBtrfs FSType = "btrfs"
+)
+
+// filesystem encapsulates the filesystem-specific parts of formatting,
+// mounting and resizing a volume, so that LinuxMountService does not need to
+// special-case every supported FSType itself.
+type filesystem interface {
+	// Format runs mkfs for this filesystem against device, passing any
+	// arguments needed to keep formatting cheap on thinly-provisioned or
+	// already-zeroed block devices.
+	Format(exec exec.Interface, device string) error
+
+	// DefaultMountOptions returns mount options that should be applied on
+	// top of whatever options the caller requested.
+	DefaultMountOptions() []string
+
+	// Resize grows the filesystem on device, which must already be
+	// mounted at mountPath.
+	Resize(exec exec.Interface, device string, mountPath string) error
+}
+
+// filesystemFor returns the filesystem implementation for fsType, defaulting
+// to ext4 when fsType is empty.
+func filesystemFor(fsType FSType) (filesystem, error) {
+	switch fsType {
+	case "":
+		return extFilesystem{mkfs: "mkfs.ext4"}, nil
+	case FSTypeExt4:
+		return extFilesystem{mkfs: "mkfs.ext4"}, nil
+	case FSTypeExt3:
+		return extFilesystem{mkfs: "mkfs.ext3"}, nil
+	case FSTypeXFS:
+		return xfsFilesystem{}, nil
+	case FSTypeBtrfs:
+		return btrfsFilesystem{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported filesystem type %q", fsType)
+	}
+}
+
+func run(exec exec.Interface, name string, args ...string) error {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %v failed: %w: %s", name, args, err, out)
+	}
+	return nil
+}
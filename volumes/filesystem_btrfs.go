@@ -0,0 +1,21 @@
+package volumes
+
+import "k8s.io/utils/exec"
+
+// btrfsFilesystem formats btrfs volumes, passing -K to mkfs.btrfs to skip
+// discarding the block device before formatting.
+type btrfsFilesystem struct{}
+
+func (f btrfsFilesystem) Format(exec exec.Interface, device string) error {
+	return run(exec, "mkfs.btrfs", "-K", device)
+}
+
+func (f btrfsFilesystem) DefaultMountOptions() []string {
+	// space_cache=v2 avoids the free-space-cache rebuild/scan that v1 (the
+	// kernel default) does on every mount.
+	return []string{"noatime", "space_cache=v2"}
+}
+
+func (f btrfsFilesystem) Resize(exec exec.Interface, device string, mountPath string) error {
+	return run(exec, "btrfs", "filesystem", "resize", "max", mountPath)
+}
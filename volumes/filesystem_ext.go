@@ -0,0 +1,26 @@
+package volumes
+
+import "k8s.io/utils/exec"
+
+// extFilesystem formats ext3/ext4 volumes using mkfs, skipping the discard
+// pass that mke2fs otherwise issues against the block device by default.
+// On Hetzner Cloud volumes, which already come back zeroed, that discard
+// pass is pure overhead and can make provisioning very slow.
+type extFilesystem struct {
+	mkfs string
+}
+
+func (f extFilesystem) Format(exec exec.Interface, device string) error {
+	return run(exec, f.mkfs, "-F", "-E", "nodiscard", device)
+}
+
+func (f extFilesystem) DefaultMountOptions() []string {
+	// noatime avoids an inode metadata write on every read, which matters
+	// for the same reason skipping mkfs discards does: fewer writes against
+	// network-attached block storage.
+	return []string{"noatime"}
+}
+
+func (f extFilesystem) Resize(exec exec.Interface, device string, mountPath string) error {
+	return run(exec, "resize2fs", device)
+}
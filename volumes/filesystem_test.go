@@ -0,0 +1,135 @@
+package volumes
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/utils/exec"
+	testingexec "k8s.io/utils/exec/testing"
+)
+
+// fakeExecCapturingArgs returns an exec.Interface whose single expected
+// Command() call hands its name/args to callback and succeeds.
+func fakeExecCapturingArgs(callback func(cmd string, args []string)) exec.Interface {
+	return &testingexec.FakeExec{
+		CommandScript: []testingexec.FakeCommandAction{
+			func(cmd string, args ...string) exec.Cmd {
+				callback(cmd, args)
+				fakeCmd := &testingexec.FakeCmd{
+					CombinedOutputScript: []testingexec.FakeCombinedOutputAction{
+						func() ([]byte, error) { return nil, nil },
+					},
+				}
+				return testingexec.InitFakeCmd(fakeCmd, cmd, args...)
+			},
+		},
+	}
+}
+
+func TestFilesystemForDispatch(t *testing.T) {
+	cases := []struct {
+		fsType FSType
+		want   filesystem
+	}{
+		{"", extFilesystem{mkfs: "mkfs.ext4"}},
+		{FSTypeExt4, extFilesystem{mkfs: "mkfs.ext4"}},
+		{FSTypeExt3, extFilesystem{mkfs: "mkfs.ext3"}},
+		{FSTypeXFS, xfsFilesystem{}},
+		{FSTypeBtrfs, btrfsFilesystem{}},
+	}
+
+	for _, c := range cases {
+		got, err := filesystemFor(c.fsType)
+		if err != nil {
+			t.Fatalf("filesystemFor(%q): %v", c.fsType, err)
+		}
+		if got != c.want {
+			t.Errorf("filesystemFor(%q) = %#v, want %#v", c.fsType, got, c.want)
+		}
+	}
+
+	if _, err := filesystemFor("zfs"); err == nil {
+		t.Error("filesystemFor(\"zfs\") should have returned an error")
+	}
+}
+
+func TestFormatArgsPerFSType(t *testing.T) {
+	cases := []struct {
+		name string
+		fs   filesystem
+		cmd  string
+		args []string
+	}{
+		{"ext4", extFilesystem{mkfs: "mkfs.ext4"}, "mkfs.ext4", []string{"-F", "-E", "nodiscard", "/dev/fake"}},
+		{"ext3", extFilesystem{mkfs: "mkfs.ext3"}, "mkfs.ext3", []string{"-F", "-E", "nodiscard", "/dev/fake"}},
+		{"xfs", xfsFilesystem{}, "mkfs.xfs", []string{"-K", "/dev/fake"}},
+		{"btrfs", btrfsFilesystem{}, "mkfs.btrfs", []string{"-K", "/dev/fake"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var gotCmd string
+			var gotArgs []string
+			fakeExec := fakeExecCapturingArgs(func(cmd string, args []string) {
+				gotCmd = cmd
+				gotArgs = args
+			})
+
+			if err := c.fs.Format(fakeExec, "/dev/fake"); err != nil {
+				t.Fatalf("Format: %v", err)
+			}
+			if gotCmd != c.cmd {
+				t.Errorf("Format command = %q, want %q", gotCmd, c.cmd)
+			}
+			if !reflect.DeepEqual(gotArgs, c.args) {
+				t.Errorf("Format args = %v, want %v", gotArgs, c.args)
+			}
+		})
+	}
+}
+
+func TestDefaultMountOptionsAreNotEmpty(t *testing.T) {
+	for _, fs := range []filesystem{
+		extFilesystem{mkfs: "mkfs.ext4"},
+		xfsFilesystem{},
+		btrfsFilesystem{},
+	} {
+		if len(fs.DefaultMountOptions()) == 0 {
+			t.Errorf("%#v.DefaultMountOptions() is empty, want filesystem-specific tuning", fs)
+		}
+	}
+}
+
+func TestResizeCommandPerFSType(t *testing.T) {
+	cases := []struct {
+		name string
+		fs   filesystem
+		cmd  string
+		args []string
+	}{
+		{"ext4", extFilesystem{mkfs: "mkfs.ext4"}, "resize2fs", []string{"/dev/fake"}},
+		{"xfs", xfsFilesystem{}, "xfs_growfs", []string{"/mnt/fake"}},
+		{"btrfs", btrfsFilesystem{}, "btrfs", []string{"filesystem", "resize", "max", "/mnt/fake"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var gotCmd string
+			var gotArgs []string
+			fakeExec := fakeExecCapturingArgs(func(cmd string, args []string) {
+				gotCmd = cmd
+				gotArgs = args
+			})
+
+			if err := c.fs.Resize(fakeExec, "/dev/fake", "/mnt/fake"); err != nil {
+				t.Fatalf("Resize: %v", err)
+			}
+			if gotCmd != c.cmd {
+				t.Errorf("Resize command = %q, want %q", gotCmd, c.cmd)
+			}
+			if !reflect.DeepEqual(gotArgs, c.args) {
+				t.Errorf("Resize args = %v, want %v", gotArgs, c.args)
+			}
+		})
+	}
+}
@@ -0,0 +1,19 @@
+package volumes
+
+import "k8s.io/utils/exec"
+
+// xfsFilesystem formats xfs volumes, passing -K to mkfs.xfs to skip
+// discarding the block device before formatting.
+type xfsFilesystem struct{}
+
+func (f xfsFilesystem) Format(exec exec.Interface, device string) error {
+	return run(exec, "mkfs.xfs", "-K", device)
+}
+
+func (f xfsFilesystem) DefaultMountOptions() []string {
+	return []string{"noatime"}
+}
+
+func (f xfsFilesystem) Resize(exec exec.Interface, device string, mountPath string) error {
+	return run(exec, "xfs_growfs", mountPath)
+}
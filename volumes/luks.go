@@ -0,0 +1,146 @@
+package volumes
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/utils/exec"
+
+	"hetzner.cloud/csi/csi"
+)
+
+// defaultMapperDir is where cryptsetup creates device-mapper nodes for
+// opened LUKS volumes. LinuxMountService.mapperDir defaults to it, but can
+// be overridden so tests don't need to touch /dev/mapper.
+const defaultMapperDir = "/dev/mapper"
+
+// cryptoLuksFormat is the format string GetDiskFormat reports for a LUKS
+// container's raw backing device, as opposed to the filesystem living
+// inside it once opened.
+const cryptoLuksFormat = "crypto_LUKS"
+
+// EncryptionOpts configures LUKS encryption for a staged volume.
+type EncryptionOpts struct {
+	// Passphrase unlocks the LUKS volume, formatting it first if it isn't
+	// a LUKS volume yet. It is resolved by the caller from the Kubernetes
+	// Secret referenced by node-publish-secret-name/namespace.
+	Passphrase string
+}
+
+// CryptSetup wraps the cryptsetup(8) operations LinuxMountService needs, so
+// that LUKS handling can be faked in tests.
+type CryptSetup interface {
+	IsLuks(device string) (bool, error)
+	LuksFormat(device string, passphrase string) error
+	LuksOpen(device string, mapperName string, passphrase string) error
+	LuksClose(mapperName string) error
+	LuksResize(mapperName string) error
+}
+
+// execCryptSetup implements CryptSetup by shelling out to cryptsetup(8).
+type execCryptSetup struct {
+	exec exec.Interface
+}
+
+func NewCryptSetup(exec exec.Interface) CryptSetup {
+	return execCryptSetup{exec: exec}
+}
+
+func (c execCryptSetup) IsLuks(device string) (bool, error) {
+	_, err := c.exec.Command("cryptsetup", "isLuks", device).CombinedOutput()
+	if err == nil {
+		return true, nil
+	}
+	if _, ok := err.(exec.ExitError); ok {
+		// cryptsetup isLuks exits non-zero when device has no LUKS header.
+		return false, nil
+	}
+	return false, err
+}
+
+func (c execCryptSetup) LuksFormat(device string, passphrase string) error {
+	return c.run(passphrase, "cryptsetup", "luksFormat", "--batch-mode", device)
+}
+
+func (c execCryptSetup) LuksOpen(device string, mapperName string, passphrase string) error {
+	return c.run(passphrase, "cryptsetup", "luksOpen", device, mapperName)
+}
+
+func (c execCryptSetup) LuksClose(mapperName string) error {
+	return c.run("", "cryptsetup", "luksClose", mapperName)
+}
+
+// LuksResize grows an already-open LUKS mapper device to fill its
+// underlying block device. It takes no passphrase: cryptsetup only needs
+// one to resize a volume with a detached header, which this driver never
+// creates.
+func (c execCryptSetup) LuksResize(mapperName string) error {
+	return c.run("", "cryptsetup", "resize", mapperName)
+}
+
+func (c execCryptSetup) run(stdin string, name string, args ...string) error {
+	cmd := c.exec.Command(name, args...)
+	cmd.SetStdin(strings.NewReader(stdin))
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %v failed: %w: %s", name, args, err, out)
+	}
+	return nil
+}
+
+// mapperPath returns the device-mapper path cryptsetup creates for an opened
+// LUKS volume with the given name.
+func (s *LinuxMountService) mapperPath(name string) string {
+	return filepath.Join(s.mapperDir, name)
+}
+
+// ensureLuksOpen makes sure volume's backing device is open as a LUKS
+// mapper device, formatting it first if it isn't a LUKS volume yet, and
+// returns the mapper device to mount. opened reports whether this call
+// performed the luksOpen, as opposed to finding it already open from a
+// previous Stage.
+func (s *LinuxMountService) ensureLuksOpen(volume *csi.Volume, enc *EncryptionOpts) (device string, opened bool, err error) {
+	device = s.mapperPath(volume.Name)
+
+	exists, err := s.PathExists(device)
+	if err != nil {
+		return "", false, err
+	}
+	if exists {
+		return device, false, nil
+	}
+
+	isLuks, err := s.cryptSetup.IsLuks(volume.LinuxDevice)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to check %q for LUKS header: %w", volume.LinuxDevice, err)
+	}
+	if !isLuks {
+		if err := s.cryptSetup.LuksFormat(volume.LinuxDevice, enc.Passphrase); err != nil {
+			return "", false, fmt.Errorf("failed to luksFormat %q: %w", volume.LinuxDevice, err)
+		}
+	}
+
+	if err := s.cryptSetup.LuksOpen(volume.LinuxDevice, volume.Name, enc.Passphrase); err != nil {
+		return "", false, fmt.Errorf("failed to luksOpen %q: %w", volume.LinuxDevice, err)
+	}
+
+	return device, true, nil
+}
+
+// ensureLuksClosed closes volume's LUKS mapper device if one is open. It is
+// a no-op if the volume was never LUKS-encrypted, so Unstage can call it
+// unconditionally.
+func (s *LinuxMountService) ensureLuksClosed(volume *csi.Volume) error {
+	exists, err := s.PathExists(s.mapperPath(volume.Name))
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return nil
+	}
+	if err := s.cryptSetup.LuksClose(volume.Name); err != nil {
+		return fmt.Errorf("failed to luksClose %q: %w", volume.Name, err)
+	}
+	return nil
+}
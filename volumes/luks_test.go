@@ -0,0 +1,150 @@
+package volumes
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"k8s.io/utils/mount"
+
+	"hetzner.cloud/csi/csi"
+)
+
+type fakeCryptSetup struct {
+	isLuks    bool
+	formatted []string
+	opened    []string
+	closed    []string
+	resized   []string
+}
+
+func (f *fakeCryptSetup) IsLuks(device string) (bool, error) {
+	return f.isLuks, nil
+}
+
+func (f *fakeCryptSetup) LuksFormat(device string, passphrase string) error {
+	f.formatted = append(f.formatted, device)
+	f.isLuks = true
+	return nil
+}
+
+func (f *fakeCryptSetup) LuksOpen(device string, mapperName string, passphrase string) error {
+	f.opened = append(f.opened, mapperName)
+	return nil
+}
+
+func (f *fakeCryptSetup) LuksClose(mapperName string) error {
+	f.closed = append(f.closed, mapperName)
+	return nil
+}
+
+func (f *fakeCryptSetup) LuksResize(mapperName string) error {
+	f.resized = append(f.resized, mapperName)
+	return nil
+}
+
+func newTestLinuxMountService(t *testing.T, crypt CryptSetup) *LinuxMountService {
+	t.Helper()
+	return &LinuxMountService{
+		logger: log.NewNopLogger(),
+		mounter: &mount.SafeFormatAndMount{
+			Interface: mount.NewFakeMounter(nil),
+		},
+		cryptSetup: crypt,
+		mapperDir:  t.TempDir(),
+	}
+}
+
+func TestEnsureLuksOpenFormatsWhenNotYetLuks(t *testing.T) {
+	crypt := &fakeCryptSetup{isLuks: false}
+	svc := newTestLinuxMountService(t, crypt)
+	volume := &csi.Volume{Name: "pvc-test", LinuxDevice: "/dev/fake"}
+
+	device, opened, err := svc.ensureLuksOpen(volume, &EncryptionOpts{Passphrase: "secret"})
+	if err != nil {
+		t.Fatalf("ensureLuksOpen: %v", err)
+	}
+	if !opened {
+		t.Error("expected ensureLuksOpen to report a fresh luksOpen")
+	}
+	if want := svc.mapperPath(volume.Name); device != want {
+		t.Errorf("device = %q, want %q", device, want)
+	}
+	if len(crypt.formatted) != 1 || crypt.formatted[0] != volume.LinuxDevice {
+		t.Errorf("expected one luksFormat call for %q, got %v", volume.LinuxDevice, crypt.formatted)
+	}
+	if len(crypt.opened) != 1 || crypt.opened[0] != volume.Name {
+		t.Errorf("expected one luksOpen call for %q, got %v", volume.Name, crypt.opened)
+	}
+}
+
+func TestEnsureLuksOpenSkipsFormatWhenAlreadyLuks(t *testing.T) {
+	crypt := &fakeCryptSetup{isLuks: true}
+	svc := newTestLinuxMountService(t, crypt)
+	volume := &csi.Volume{Name: "pvc-test", LinuxDevice: "/dev/fake"}
+
+	if _, _, err := svc.ensureLuksOpen(volume, &EncryptionOpts{Passphrase: "secret"}); err != nil {
+		t.Fatalf("ensureLuksOpen: %v", err)
+	}
+	if len(crypt.formatted) != 0 {
+		t.Errorf("expected luksFormat to be skipped for an existing LUKS device, got %d calls", len(crypt.formatted))
+	}
+	if len(crypt.opened) != 1 {
+		t.Errorf("expected one luksOpen call, got %d", len(crypt.opened))
+	}
+}
+
+func TestEnsureLuksOpenIsIdempotentWhenMapperAlreadyExists(t *testing.T) {
+	crypt := &fakeCryptSetup{isLuks: true}
+	svc := newTestLinuxMountService(t, crypt)
+	volume := &csi.Volume{Name: "pvc-test", LinuxDevice: "/dev/fake"}
+
+	if err := os.WriteFile(filepath.Join(svc.mapperDir, volume.Name), nil, 0600); err != nil {
+		t.Fatalf("failed to seed mapper device: %v", err)
+	}
+
+	device, opened, err := svc.ensureLuksOpen(volume, &EncryptionOpts{Passphrase: "secret"})
+	if err != nil {
+		t.Fatalf("ensureLuksOpen: %v", err)
+	}
+	if opened {
+		t.Error("expected ensureLuksOpen not to report a fresh open for an already-open mapper")
+	}
+	if want := svc.mapperPath(volume.Name); device != want {
+		t.Errorf("device = %q, want %q", device, want)
+	}
+	if len(crypt.opened) != 0 {
+		t.Errorf("expected luksOpen to be skipped for an already-open mapper, got %d calls", len(crypt.opened))
+	}
+}
+
+func TestEnsureLuksClosedIsNoopWithoutMapper(t *testing.T) {
+	crypt := &fakeCryptSetup{}
+	svc := newTestLinuxMountService(t, crypt)
+	volume := &csi.Volume{Name: "pvc-test", LinuxDevice: "/dev/fake"}
+
+	if err := svc.ensureLuksClosed(volume); err != nil {
+		t.Fatalf("ensureLuksClosed: %v", err)
+	}
+	if len(crypt.closed) != 0 {
+		t.Errorf("expected no luksClose call for an unencrypted volume, got %d", len(crypt.closed))
+	}
+}
+
+func TestEnsureLuksClosedClosesExistingMapper(t *testing.T) {
+	crypt := &fakeCryptSetup{}
+	svc := newTestLinuxMountService(t, crypt)
+	volume := &csi.Volume{Name: "pvc-test", LinuxDevice: "/dev/fake"}
+
+	if err := os.WriteFile(filepath.Join(svc.mapperDir, volume.Name), nil, 0600); err != nil {
+		t.Fatalf("failed to seed mapper device: %v", err)
+	}
+
+	if err := svc.ensureLuksClosed(volume); err != nil {
+		t.Fatalf("ensureLuksClosed: %v", err)
+	}
+	if len(crypt.closed) != 1 || crypt.closed[0] != volume.Name {
+		t.Errorf("expected one luksClose call for %q, got %v", volume.Name, crypt.closed)
+	}
+}
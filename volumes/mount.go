@@ -9,19 +9,20 @@ import (
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
-	"k8s.io/utils/exec"
 	"k8s.io/utils/mount"
 
 	"hetzner.cloud/csi/csi"
+	"hetzner.cloud/csi/driver"
 )
 
-const DefaultFSType = "ext4"
+const DefaultFSType = FSTypeExt4
 
 // MountOpts specifies options for mounting a volume.
 type MountOpts struct {
-	FSType     string
+	FSType     FSType
 	Readonly   bool
 	Additional []string // Additional mount options/flags passed to /bin/mount
+	Encryption *EncryptionOpts
 }
 
 func NewMountOpts() MountOpts {
@@ -37,49 +38,105 @@ type MountService interface {
 	PublishFilesystem(volume *csi.Volume, targetPath string, stagingTargetPath string, opts MountOpts) error
 	PublishBlock(volume *csi.Volume, targetPath string, opts MountOpts) error
 	Unpublish(volume *csi.Volume, targetPath string) error
+	Resize(volume *csi.Volume, devicePath string, targetPath string) error
 	PathExists(path string) (bool, error)
 }
 
 // LinuxMountService mounts volumes on a Linux system.
 type LinuxMountService struct {
-	logger  log.Logger
-	mounter *mount.SafeFormatAndMount
+	logger     log.Logger
+	mounter    *mount.SafeFormatAndMount
+	cryptSetup CryptSetup
+	mapperDir  string
 }
 
-func NewLinuxMountService(logger log.Logger) *LinuxMountService {
+func NewLinuxMountService(logger log.Logger, mounter *mount.SafeFormatAndMount, cryptSetup CryptSetup) *LinuxMountService {
 	return &LinuxMountService{
-		logger: logger,
-		mounter: &mount.SafeFormatAndMount{
-			Interface: mount.New(""),
-			Exec:      exec.New(),
-		},
+		logger:     logger,
+		mounter:    mounter,
+		cryptSetup: cryptSetup,
+		mapperDir:  defaultMapperDir,
 	}
 }
 
 func (s *LinuxMountService) Stage(volume *csi.Volume, stagingTargetPath string, opts MountOpts) error {
+	fsType := opts.FSType
+	if fsType == "" {
+		fsType = DefaultFSType
+	}
+	fs, err := filesystemFor(fsType)
+	if err != nil {
+		return err
+	}
+
 	level.Debug(s.logger).Log(
 		"msg", "staging volume",
 		"volume-name", volume.Name,
 		"staging-target-path", stagingTargetPath,
-		"fs-type", opts.FSType,
+		"fs-type", fsType,
 	)
 
-	isNotMountPoint, err := s.mounter.Interface.IsLikelyNotMountPoint(stagingTargetPath)
+	state, err := s.mountStateOf(stagingTargetPath)
 	if err != nil {
 		if os.IsNotExist(err) {
 			if err := s.makeDir(stagingTargetPath); err != nil {
 				return err
 			}
-			isNotMountPoint = true
+			state = mountStateNotMounted
 		} else {
 			return err
 		}
 	}
-	if !isNotMountPoint {
-		return fmt.Errorf("%q is not a valid mount point", stagingTargetPath)
+	if state == mountStateCorrupted {
+		level.Info(s.logger).Log(
+			"msg", "found corrupted mount, re-staging",
+			"staging-target-path", stagingTargetPath,
+		)
+		s.forceUnmount(stagingTargetPath)
+		state = mountStateNotMounted
+	}
+	if state == mountStateMounted {
+		// Already staged with a healthy mount: NodeStageVolume must be
+		// idempotent, so report success instead of erroring.
+		return nil
+	}
+
+	device := volume.LinuxDevice
+	luksOpened := false
+	if opts.Encryption != nil {
+		device, luksOpened, err = s.ensureLuksOpen(volume, opts.Encryption)
+		if err != nil {
+			return err
+		}
+	}
+
+	cleanupLuksOnFailure := func() {
+		if !luksOpened {
+			return
+		}
+		if closeErr := s.ensureLuksClosed(volume); closeErr != nil {
+			level.Warn(s.logger).Log("msg", "failed to clean up LUKS mapper after failed stage", "err", closeErr)
+		}
 	}
 
-	return s.mounter.FormatAndMount(volume.LinuxDevice, stagingTargetPath, opts.FSType, nil)
+	existingFormat, err := s.mounter.GetDiskFormat(device)
+	if err != nil {
+		cleanupLuksOnFailure()
+		return fmt.Errorf("failed to determine filesystem of %q: %w", device, err)
+	}
+	if existingFormat == "" {
+		if err := fs.Format(s.mounter.Exec, device); err != nil {
+			cleanupLuksOnFailure()
+			return fmt.Errorf("failed to format %q as %s: %w", device, fsType, err)
+		}
+	}
+
+	mountOptions := append(fs.DefaultMountOptions(), opts.Additional...)
+	if err := s.mounter.Interface.Mount(device, stagingTargetPath, string(fsType), mountOptions); err != nil {
+		cleanupLuksOnFailure()
+		return err
+	}
+	return nil
 }
 
 func (s *LinuxMountService) Unstage(volume *csi.Volume, stagingTargetPath string) error {
@@ -88,7 +145,29 @@ func (s *LinuxMountService) Unstage(volume *csi.Volume, stagingTargetPath string
 		"volume-name", volume.Name,
 		"staging-target-path", stagingTargetPath,
 	)
-	return s.mounter.Interface.Unmount(stagingTargetPath)
+
+	state, err := s.mountStateOf(stagingTargetPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		state = mountStateNotMounted
+	}
+
+	switch state {
+	case mountStateCorrupted:
+		level.Info(s.logger).Log(
+			"msg", "cleaning up corrupted mount",
+			"staging-target-path", stagingTargetPath,
+		)
+		s.forceUnmount(stagingTargetPath)
+	case mountStateMounted:
+		if err := s.mounter.Interface.Unmount(stagingTargetPath); err != nil {
+			return err
+		}
+	}
+
+	return s.ensureLuksClosed(volume)
 }
 
 func (s *LinuxMountService) PublishFilesystem(volume *csi.Volume, targetPath string, stagingTargetPath string, opts MountOpts) error {
@@ -105,7 +184,22 @@ func (s *LinuxMountService) PublishFilesystem(volume *csi.Volume, targetPath str
 	if err := s.makeDir(targetPath); err != nil {
 		return err
 	}
-	return s.mountBind(stagingTargetPath, targetPath, opts)
+
+	state, err := s.mountStateOf(targetPath)
+	if err != nil {
+		return err
+	}
+	if state == mountStateCorrupted {
+		level.Info(s.logger).Log(
+			"msg", "found corrupted mount, re-publishing",
+			"target-path", targetPath,
+		)
+		s.forceUnmount(targetPath)
+	}
+
+	return s.resolveAndMount(volume, targetPath, opts, func() error {
+		return s.mountBind(stagingTargetPath, targetPath, opts)
+	})
 }
 
 func (s *LinuxMountService) PublishBlock(volume *csi.Volume, targetPath string, opts MountOpts) error {
@@ -141,16 +235,99 @@ func (s *LinuxMountService) PublishBlock(volume *csi.Volume, targetPath string,
 		return status.Errorf(codes.Internal, "failed to create block mount file %q: %v", targetPath, err)
 	}
 
-	return s.mountBind(volume.LinuxDevice, targetPath, opts)
+	return s.resolveAndMount(volume, targetPath, opts, func() error {
+		return s.mountBind(volume.LinuxDevice, targetPath, opts)
+	})
 }
 
 func (s *LinuxMountService) Unpublish(volume *csi.Volume, targetPath string) error {
+	// The CO is only required to pass targetPath here, not fsType or driver
+	// name, so recover that context from the state file resolveAndMount
+	// wrote at publish time.
+	data, exists, err := loadVolumeData(targetPath)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to read volume data for %q: %v", targetPath, err)
+	}
+	if exists && data.DriverName != driver.PluginName {
+		return status.Errorf(codes.Internal, "refusing to unpublish %q: published by driver %q, not %q", targetPath, data.DriverName, driver.PluginName)
+	}
+
 	level.Debug(s.logger).Log(
 		"msg", "unpublishing volume",
 		"volume-name", volume.Name,
 		"target-path", targetPath,
+		"fs-type", data.FSType,
 	)
-	return s.mounter.Interface.Unmount(targetPath)
+
+	state, err := s.mountStateOf(targetPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		state = mountStateNotMounted
+	}
+
+	switch state {
+	case mountStateCorrupted:
+		level.Info(s.logger).Log(
+			"msg", "cleaning up corrupted mount",
+			"target-path", targetPath,
+		)
+		s.forceUnmount(targetPath)
+	case mountStateMounted:
+		if err := s.mounter.Interface.Unmount(targetPath); err != nil {
+			return err
+		}
+	}
+
+	return removeVolumeData(targetPath)
+}
+
+// resolveAndMount is the shared implementation behind PublishFilesystem and
+// PublishBlock: it persists the parameters a publish was made with next to
+// the target path, so that a republish (e.g. after a kubelet restart) can be
+// recognized as idempotent, and a conflicting one rejected, instead of
+// silently stacking another mount on top.
+func (s *LinuxMountService) resolveAndMount(volume *csi.Volume, targetPath string, opts MountOpts, doMount func() error) error {
+	wantData := volumeData{
+		SpecVolID:    volume.Name,
+		VolumeHandle: volume.Name,
+		DriverName:   driver.PluginName,
+		Readonly:     opts.Readonly,
+		FSType:       opts.FSType,
+		MountFlags:   opts.Additional,
+	}
+
+	existingData, exists, err := loadVolumeData(targetPath)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to read volume data for %q: %v", targetPath, err)
+	}
+	if exists {
+		if existingData.VolumeHandle != wantData.VolumeHandle {
+			return status.Errorf(codes.AlreadyExists, "target path %q is already published for volume %q", targetPath, existingData.VolumeHandle)
+		}
+		if !volumeDataEqual(existingData, wantData) {
+			return status.Errorf(codes.InvalidArgument, "target path %q is already published with different options", targetPath)
+		}
+
+		// The state file alone isn't proof the volume is still mounted: it
+		// lives under /var/lib/kubelet and survives a node reboot, while the
+		// mount itself does not. Only short-circuit if the mount is live;
+		// otherwise fall through and remount.
+		isMountPoint, err := IsMountPoint(s.mounter, targetPath)
+		if err != nil {
+			return status.Errorf(codes.Internal, "failed to check mount state of %q: %v", targetPath, err)
+		}
+		if isMountPoint {
+			return nil
+		}
+	}
+
+	if err := doMount(); err != nil {
+		return err
+	}
+
+	return saveVolumeData(targetPath, wantData)
 }
 
 func (s *LinuxMountService) PathExists(path string) (bool, error) {
@@ -197,5 +374,5 @@ func (s *LinuxMountService) mountBind(sourcePath string, targetPath string, opts
 	}
 	options = append(options, opts.Additional...)
 
-	return s.mounter.Interface.Mount(sourcePath, targetPath, opts.FSType, options)
+	return s.mounter.Interface.Mount(sourcePath, targetPath, string(opts.FSType), options)
 }
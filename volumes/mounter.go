@@ -0,0 +1,29 @@
+package volumes
+
+import (
+	"k8s.io/utils/exec"
+	"k8s.io/utils/mount"
+)
+
+// NewSafeFormatAndMount constructs the mount.SafeFormatAndMount shared by
+// LinuxMountService across all node RPCs. It should be created once at
+// driver start-up and reused from there: constructing one per call repeats
+// k8s.io/mount-utils' runtime detection probes (and their log lines, e.g.
+// "Detected umount with safe 'not mounted' behavior") on every
+// Stage/Unstage/Publish/Unpublish.
+func NewSafeFormatAndMount() *mount.SafeFormatAndMount {
+	return &mount.SafeFormatAndMount{
+		Interface: mount.New(""),
+		Exec:      exec.New(),
+	}
+}
+
+// IsMountPoint reports whether path is currently mounted, using the shared
+// mounter rather than constructing a new one.
+func IsMountPoint(mounter *mount.SafeFormatAndMount, path string) (bool, error) {
+	isNotMountPoint, err := mounter.Interface.IsLikelyNotMountPoint(path)
+	if err != nil {
+		return false, err
+	}
+	return !isNotMountPoint, nil
+}
@@ -0,0 +1,61 @@
+package volumes
+
+import (
+	"github.com/go-kit/kit/log/level"
+	"k8s.io/utils/mount"
+)
+
+// mountState describes what, if anything, is mounted at a given path.
+type mountState int
+
+const (
+	mountStateUnknown mountState = iota
+	mountStateNotMounted
+	mountStateMounted
+	mountStateCorrupted
+)
+
+// mountStateOf inspects path and reports whether it is unmounted, mounted,
+// or a corrupted mount (e.g. "transport endpoint is not connected" after the
+// backing device disappeared). A corrupted mount is reported without error,
+// since discovering one is an expected outcome, not a failure of the check
+// itself.
+func (s *LinuxMountService) mountStateOf(path string) (mountState, error) {
+	isMountPoint, err := IsMountPoint(s.mounter, path)
+	if err != nil {
+		if mount.IsCorruptedMnt(err) {
+			return mountStateCorrupted, nil
+		}
+		return mountStateUnknown, err
+	}
+	if isMountPoint {
+		return mountStateMounted, nil
+	}
+	return mountStateNotMounted, nil
+}
+
+// forceUnmount clears path of a corrupted mount. A plain Unmount often isn't
+// enough here: a "transport endpoint is not connected" mount typically
+// rejects a regular unmount too, since the kernel still considers it busy.
+// So once Unmount fails, fall back to `umount -f -l`, which detaches the
+// mount from the namespace immediately (-l, lazy) while forcing the unmount
+// of an unreachable filesystem (-f), instead of leaving Stage to layer a new
+// mount on top of one that's still there.
+func (s *LinuxMountService) forceUnmount(path string) {
+	if err := s.mounter.Interface.Unmount(path); err == nil {
+		return
+	}
+	level.Warn(s.logger).Log(
+		"msg", "unmount of corrupted mount failed, falling back to forced/lazy umount",
+		"path", path,
+	)
+
+	if out, err := s.mounter.Exec.Command("umount", "-f", "-l", path).CombinedOutput(); err != nil {
+		level.Warn(s.logger).Log(
+			"msg", "forced/lazy umount of corrupted mount also failed",
+			"path", path,
+			"err", err,
+			"output", string(out),
+		)
+	}
+}
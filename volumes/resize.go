@@ -0,0 +1,71 @@
+package volumes
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/kit/log/level"
+	"k8s.io/utils/exec"
+
+	"hetzner.cloud/csi/csi"
+)
+
+// Resize grows the filesystem at targetPath to match the current size of
+// devicePath. For block volumes, which have no filesystem, this is a no-op:
+// the larger size is already visible to the consumer as soon as the kernel
+// notices the resized device. For a LUKS-encrypted volume, devicePath is the
+// raw, still-encrypted device, so GetDiskFormat reports "crypto_LUKS"
+// instead of the filesystem inside it; Resize must grow the open LUKS
+// mapper first and inspect that instead.
+func (s *LinuxMountService) Resize(volume *csi.Volume, devicePath string, targetPath string) error {
+	level.Debug(s.logger).Log(
+		"msg", "resizing volume",
+		"volume-name", volume.Name,
+		"device-path", devicePath,
+		"target-path", targetPath,
+	)
+
+	existingFormat, err := s.mounter.GetDiskFormat(devicePath)
+	if err != nil {
+		return fmt.Errorf("failed to determine filesystem of %q: %w", devicePath, err)
+	}
+
+	if existingFormat == cryptoLuksFormat {
+		mapperDevice := s.mapperPath(volume.Name)
+		if err := s.cryptSetup.LuksResize(volume.Name); err != nil {
+			return fmt.Errorf("failed to resize LUKS mapper %q: %w", volume.Name, err)
+		}
+		devicePath = mapperDevice
+		existingFormat, err = s.mounter.GetDiskFormat(devicePath)
+		if err != nil {
+			return fmt.Errorf("failed to determine filesystem of %q: %w", devicePath, err)
+		}
+	}
+
+	if existingFormat == "" {
+		return nil
+	}
+
+	fs, err := filesystemFor(FSType(existingFormat))
+	if err != nil {
+		return err
+	}
+	return fs.Resize(s.mounter.Exec, devicePath, targetPath)
+}
+
+// BlockDeviceSize returns the current size in bytes of the block device at
+// devicePath, as reported by the kernel. NodeExpandVolume uses it to report
+// the post-resize capacity of raw block volumes, which have no filesystem
+// for Resize to grow.
+func BlockDeviceSize(exec exec.Interface, devicePath string) (int64, error) {
+	out, err := exec.Command("blockdev", "--getsize64", devicePath).CombinedOutput()
+	if err != nil {
+		return 0, fmt.Errorf("blockdev --getsize64 %q failed: %w: %s", devicePath, err, out)
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse size of %q: %w", devicePath, err)
+	}
+	return size, nil
+}
@@ -0,0 +1,79 @@
+package volumes
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// volumeData is the subset of a published volume's parameters persisted
+// next to its mount point, mirroring kubelet's CSI vol_data.json. It lets
+// Unpublish and republish recover the parameters a bare target path doesn't
+// carry on its own.
+type volumeData struct {
+	SpecVolID    string   `json:"specVolID"`
+	VolumeHandle string   `json:"volumeHandle"`
+	DriverName   string   `json:"driverName"`
+	Readonly     bool     `json:"readonly"`
+	FSType       FSType   `json:"fsType,omitempty"`
+	MountFlags   []string `json:"mountFlags,omitempty"`
+}
+
+func volumeDataPath(targetPath string) string {
+	return targetPath + ".vol_data.json"
+}
+
+func loadVolumeData(targetPath string) (data volumeData, exists bool, err error) {
+	raw, err := os.ReadFile(volumeDataPath(targetPath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return volumeData{}, false, nil
+		}
+		return volumeData{}, false, err
+	}
+
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return volumeData{}, false, err
+	}
+	return data, true, nil
+}
+
+func saveVolumeData(targetPath string, data volumeData) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(volumeDataPath(targetPath), raw, os.FileMode(0644))
+}
+
+func removeVolumeData(targetPath string) error {
+	err := os.Remove(volumeDataPath(targetPath))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// volumeDataEqual compares two volumeData values field by field rather than
+// with reflect.DeepEqual, since MountFlags round-trips through
+// `json:",omitempty"` and turns a non-nil empty []string{} into a nil slice
+// on reload; DeepEqual would treat that as a conflicting republish.
+func volumeDataEqual(a, b volumeData) bool {
+	return a.SpecVolID == b.SpecVolID &&
+		a.VolumeHandle == b.VolumeHandle &&
+		a.DriverName == b.DriverName &&
+		a.Readonly == b.Readonly &&
+		a.FSType == b.FSType &&
+		stringSlicesEqual(a.MountFlags, b.MountFlags)
+}
+
+func stringSlicesEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}